@@ -0,0 +1,608 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+
+	dataflow "google.golang.org/api/dataflow/v1b3"
+)
+
+// dataflowFlexTemplateRuntimeEnvironmentKeys lists the runtime environment
+// keys that now have a first-class, typed schema attribute. Users are no
+// longer allowed to set these through the free-form `parameters` map, since
+// doing so would race with the typed attribute and silently be overridden.
+var dataflowFlexTemplateRuntimeEnvironmentKeys = []string{
+	"serviceAccount",
+	"network",
+	"subnetwork",
+	"zone",
+	"region",
+	"maxWorkers",
+	"tempLocation",
+	"machineType",
+	"kmsKeyName",
+	"ipConfiguration",
+	"additionalExperiments",
+}
+
+func resourceDataflowFlexTemplateJob() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDataflowFlexTemplateJobCreate,
+		Read:   resourceDataflowFlexTemplateJobRead,
+		Update: resourceDataflowFlexTemplateJobUpdate,
+		Delete: resourceDataflowFlexTemplateJobDelete,
+
+		// container_spec_gcs_path, parameters, labels and additional_experiments
+		// only force recreation of the job when on_delete is "cancel" (a
+		// drained/streaming job is instead relaunched in place by Update,
+		// matching google_dataflow_job).
+		CustomizeDiff: customdiff.All(
+			customdiff.ForceNewIf("container_spec_gcs_path", dataflowFlexTemplateOnDeleteIsCancel),
+			customdiff.ForceNewIf("parameters", dataflowFlexTemplateOnDeleteIsCancel),
+			customdiff.ForceNewIf("labels", dataflowFlexTemplateOnDeleteIsCancel),
+			customdiff.ForceNewIf("additional_experiments", dataflowFlexTemplateOnDeleteIsCancel),
+		),
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(10 * time.Minute),
+			Delete: schema.DefaultTimeout(10 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"container_spec_gcs_path": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: `The GCS path to the Dataflow job Flex Template.`,
+			},
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: `A unique name for the resource, required by Dataflow.`,
+			},
+			"parameters": {
+				Type:         schema.TypeMap,
+				Optional:     true,
+				Elem:         &schema.Schema{Type: schema.TypeString},
+				ValidateFunc: validateDataflowFlexTemplateParameters,
+				Description:  `Key/Value pairs to be passed to the Dataflow job (as used in the template). Only applicable for runtime parameters declared by the template, not runtime environment settings which should use one of the top-level attributes below (e.g. service_account_email).`,
+			},
+			"service_account_email": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The Service Account email used to create the job.`,
+			},
+			"network": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The network to which VMs will be assigned. If it is not provided, "default" will be used.`,
+			},
+			"subnetwork": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The subnetwork to which VMs will be assigned. Should be of the form "regions/REGION/subnetworks/SUBNETWORK". If the subnetwork is located in a Shared VPC network, you must use the complete URL.`,
+			},
+			"zone": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The zone in which the created job should run. If it is not provided, no zone is sent with the launch request; the provider-level zone is not used.`,
+			},
+			"max_workers": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The maximum number of Google Compute Engine instances to be made available to your pipeline during execution, from 1 to 1000.`,
+			},
+			"temp_location": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The Cloud Storage path to use for temporary files. Must be a valid Cloud Storage URL, beginning with gs://.`,
+			},
+			"machine_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The machine type to use for the job.`,
+			},
+			"kms_key_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The name for the Cloud KMS key for the job. Key format is: projects/PROJECT_ID/locations/LOCATION/keyRings/KEY_RING/cryptoKeys/KEY`,
+			},
+			"ip_configuration": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.StringInSlice([]string{"WORKER_IP_PUBLIC", "WORKER_IP_PRIVATE", ""}, false),
+				Description:  `The configuration for VM IPs. Options are "WORKER_IP_PUBLIC" or "WORKER_IP_PRIVATE".`,
+			},
+			"labels": {
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				DiffSuppressFunc: resourceDataflowFlexTemplateLabelsDiffSuppress,
+				Description:      `User labels to be specified for the job. Keys and values should follow the restrictions specified in the labeling restrictions page. NOTE: Google-provided Dataflow templates often provide default labels that begin with goog-dataflow-provided. Unless explicitly set in config, these labels will be ignored to prevent diffs on re-apply.`,
+			},
+			"additional_experiments": {
+				Type:             schema.TypeSet,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				DiffSuppressFunc: resourceDataflowFlexTemplateAdditionalExperimentsDiffSuppress,
+				Description:      `List of experiments that should be used by the job. An example value is ["enable_stackdriver_agent_metrics"].`,
+			},
+			"num_workers": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The initial number of Google Compute Engine instances for the job.`,
+			},
+			"launcher_machine_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `The machine type to use for launching the job. The default is n1-standard-1.`,
+			},
+			"enable_streaming_engine": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `Enable/disable the use of Streaming Engine for the job.`,
+			},
+			"sdk_container_image": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: `Docker registry location of container image to use for the worker harness. Default is the container for the version of the SDK. Note this field is only valid for portable pipelines.`,
+			},
+			"project": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: `The project in which the resource belongs. If it is not provided, the provider project is used.`,
+			},
+			"region": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: `The region in which the created job should run.`,
+			},
+			"on_delete": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "drain",
+				ValidateFunc: validation.StringInSlice([]string{"cancel", "drain"}, false),
+				Description:  `One of "drain" or "cancel". Specifies behavior of deletion during terraform destroy.`,
+			},
+			"skip_wait_on_job_termination": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: `If true, deleting the resource returns as soon as termination of the job has been requested, without polling its state, and an in-place update does not wait for the old job to reach JOB_STATE_UPDATED. This is useful if you are not running a streaming job, or if you do not expect your pipeline to be drained/cancelled within the resource's timeouts.`,
+			},
+			"job_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: `The unique ID of this job.`,
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: `The current state of the resource, selected from the JobState enum.`,
+			},
+			"replaced_by_job_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: `If another job is created that supersedes this one (e.g. through an in-place update), the id of the new job.`,
+			},
+		},
+	}
+}
+
+// dataflowFlexTemplateOnDeleteIsCancel reports whether on_delete is "cancel",
+// in which case container_spec_gcs_path/parameters changes cannot be applied
+// to a running job and must force recreation instead.
+func dataflowFlexTemplateOnDeleteIsCancel(_ context.Context, d *schema.ResourceDiff, meta interface{}) bool {
+	return d.Get("on_delete").(string) == "cancel"
+}
+
+// dataflowFlexTemplateServiceLabelPrefixes lists label key prefixes that
+// Dataflow itself stamps onto a job (e.g. goog-dataflow-provided-template-name
+// for Google-provided templates). Only a missing label matching one of these
+// is assumed to be a service addition; any other missing label was removed
+// by the user and must still surface as a real diff.
+var dataflowFlexTemplateServiceLabelPrefixes = []string{
+	"goog-dataflow-provided-",
+}
+
+func isDataflowFlexTemplateServiceLabel(key string) bool {
+	for _, prefix := range dataflowFlexTemplateServiceLabelPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dataflowFlexTemplateServiceExperiments lists experiments Dataflow can add
+// to a job on its own as a side effect of other settings (e.g. enabling
+// Streaming Engine). Like dataflowFlexTemplateServiceLabelPrefixes, only
+// these are ignored when missing from config; any other missing experiment
+// was removed by the user and must still surface as a real diff.
+var dataflowFlexTemplateServiceExperiments = map[string]bool{
+	"enable_streaming_engine": true,
+	"use_runner_v2":           true,
+}
+
+// resourceDataflowFlexTemplateLabelsDiffSuppress ignores labels that
+// Dataflow itself stamped onto the job and that are absent from config. A
+// label the user had configured and then removed is not ignored.
+func resourceDataflowFlexTemplateLabelsDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	o, n := d.GetChange("labels")
+	oldLabels := o.(map[string]interface{})
+	newLabels := n.(map[string]interface{})
+
+	for key, newVal := range newLabels {
+		if oldVal, ok := oldLabels[key]; !ok || oldVal != newVal {
+			return false
+		}
+	}
+
+	for key := range oldLabels {
+		if _, ok := newLabels[key]; ok {
+			continue
+		}
+		if !isDataflowFlexTemplateServiceLabel(key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resourceDataflowFlexTemplateAdditionalExperimentsDiffSuppress ignores
+// experiments Dataflow adds to the job that were not present in config. An
+// experiment the user had configured and then removed is not ignored.
+func resourceDataflowFlexTemplateAdditionalExperimentsDiffSuppress(k, old, new string, d *schema.ResourceData) bool {
+	o, n := d.GetChange("additional_experiments")
+	oldExperiments := o.(*schema.Set)
+	newExperiments := n.(*schema.Set)
+
+	if newExperiments.Difference(oldExperiments).Len() > 0 {
+		return false
+	}
+
+	for _, v := range oldExperiments.Difference(newExperiments).List() {
+		if !dataflowFlexTemplateServiceExperiments[v.(string)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateDataflowFlexTemplateParameters(v interface{}, k string) (ws []string, errors []error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	for _, reserved := range dataflowFlexTemplateRuntimeEnvironmentKeys {
+		if _, ok := m[reserved]; ok {
+			errors = append(errors, fmt.Errorf("%q: key %q is configured through a top-level attribute on this resource and can no longer be set inside `parameters`", k, reserved))
+		}
+	}
+	return
+}
+
+func resourceDataflowFlexTemplateJobCreate(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*Config)
+	userAgent, err := generateUserAgentString(d, config.userAgent)
+	if err != nil {
+		return err
+	}
+
+	project, err := getProject(d, config)
+	if err != nil {
+		return err
+	}
+
+	region, err := getRegion(d, config)
+	if err != nil {
+		return err
+	}
+
+	request := dataflow.LaunchFlexTemplateRequest{
+		LaunchParameter: &dataflow.LaunchFlexTemplateParameter{
+			JobName:              d.Get("name").(string),
+			ContainerSpecGcsPath: d.Get("container_spec_gcs_path").(string),
+			Parameters:           expandDataflowFlexTemplateParameters(d),
+			Environment:          expandDataflowFlexTemplateRuntimeEnvironment(d),
+		},
+	}
+
+	response, err := config.NewDataflowClient(userAgent).Projects.Locations.FlexTemplates.Launch(project, region, &request).Do()
+	if err != nil {
+		return fmt.Errorf("Error submitting dataflow flex template job: %s", err)
+	}
+
+	job := response.Job
+	d.SetId(job.Id)
+	if err := d.Set("job_id", job.Id); err != nil {
+		return fmt.Errorf("Error setting job_id: %s", err)
+	}
+
+	return resourceDataflowFlexTemplateJobRead(d, meta)
+}
+
+func expandDataflowFlexTemplateParameters(d *schema.ResourceData) map[string]string {
+	params := make(map[string]string)
+	for k, v := range d.Get("parameters").(map[string]interface{}) {
+		params[k] = v.(string)
+	}
+	return params
+}
+
+func expandDataflowFlexTemplateRuntimeEnvironment(d *schema.ResourceData) *dataflow.FlexTemplateRuntimeEnvironment {
+	labels := make(map[string]string)
+	for k, v := range d.Get("labels").(map[string]interface{}) {
+		labels[k] = v.(string)
+	}
+
+	experiments := make([]string, 0)
+	for _, v := range d.Get("additional_experiments").(*schema.Set).List() {
+		experiments = append(experiments, v.(string))
+	}
+
+	return &dataflow.FlexTemplateRuntimeEnvironment{
+		ServiceAccountEmail:   d.Get("service_account_email").(string),
+		Network:               d.Get("network").(string),
+		Subnetwork:            d.Get("subnetwork").(string),
+		Zone:                  d.Get("zone").(string),
+		MaxWorkers:            int64(d.Get("max_workers").(int)),
+		TempLocation:          d.Get("temp_location").(string),
+		MachineType:           d.Get("machine_type").(string),
+		KmsKeyName:            d.Get("kms_key_name").(string),
+		IpConfiguration:       d.Get("ip_configuration").(string),
+		AdditionalUserLabels:  labels,
+		AdditionalExperiments: experiments,
+		NumWorkers:            int64(d.Get("num_workers").(int)),
+		LauncherMachineType:   d.Get("launcher_machine_type").(string),
+		EnableStreamingEngine: d.Get("enable_streaming_engine").(bool),
+		SdkContainerImage:     d.Get("sdk_container_image").(string),
+	}
+}
+
+// resourceDataflowFlexTemplateJobUpdate relaunches a running streaming job
+// in place with update=true, which Dataflow implements by draining the
+// existing job and transferring its state to a newly created job. It is
+// only reachable when on_delete != "cancel"; see CustomizeDiff above.
+//
+// container_spec_gcs_path/parameters/labels/additional_experiments all flow
+// into the LaunchFlexTemplateRequest, so any of them changing requires
+// relaunching the live job; every other updatable attribute (on_delete,
+// skip_wait_on_job_termination, ...) is local/provider-side bookkeeping and
+// is just picked up by Read.
+func resourceDataflowFlexTemplateJobUpdate(d *schema.ResourceData, meta interface{}) error {
+	if !d.HasChange("container_spec_gcs_path") && !d.HasChange("parameters") &&
+		!d.HasChange("labels") && !d.HasChange("additional_experiments") {
+		return resourceDataflowFlexTemplateJobRead(d, meta)
+	}
+
+	config := meta.(*Config)
+	userAgent, err := generateUserAgentString(d, config.userAgent)
+	if err != nil {
+		return err
+	}
+
+	project, err := getProject(d, config)
+	if err != nil {
+		return err
+	}
+
+	region, err := getRegion(d, config)
+	if err != nil {
+		return err
+	}
+
+	request := dataflow.LaunchFlexTemplateRequest{
+		LaunchParameter: &dataflow.LaunchFlexTemplateParameter{
+			JobName:              d.Get("name").(string),
+			ContainerSpecGcsPath: d.Get("container_spec_gcs_path").(string),
+			Parameters:           expandDataflowFlexTemplateParameters(d),
+			Environment:          expandDataflowFlexTemplateRuntimeEnvironment(d),
+			Update:               true,
+		},
+	}
+
+	response, err := config.NewDataflowClient(userAgent).Projects.Locations.FlexTemplates.Launch(project, region, &request).Do()
+	if err != nil {
+		return fmt.Errorf("Error updating dataflow flex template job: %s", err)
+	}
+	oldJobId := d.Id()
+	newJobId := response.Job.Id
+
+	// Capture the terminal state and replaced_by_job_id of the *old* job
+	// before switching the tracked ID over to its replacement, since once
+	// d.SetId(newJobId) happens Read will only ever see the new job.
+	var oldJobState, replacedByJobId string
+	if d.Get("skip_wait_on_job_termination").(bool) {
+		replacedByJobId = newJobId
+	} else {
+		err = resource.Retry(d.Timeout(schema.TimeoutUpdate), func() *resource.RetryError {
+			j, gerr := config.NewDataflowClient(userAgent).Projects.Locations.Jobs.Get(project, region, oldJobId).Do()
+			if gerr != nil {
+				return resource.NonRetryableError(gerr)
+			}
+			if j.CurrentState != dataflowJobStateUpdated {
+				return resource.RetryableError(fmt.Errorf("job %q has not yet transitioned to %s, currently %s", oldJobId, dataflowJobStateUpdated, j.CurrentState))
+			}
+			oldJobState = j.CurrentState
+			replacedByJobId = j.ReplacedByJobId
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[DEBUG] dataflow flex template job %q updated in place, replaced by job %q", oldJobId, newJobId)
+
+	// The old job has been replaced (and, unless skip_wait_on_job_termination
+	// is set, has been seen in JOB_STATE_UPDATED); start tracking the job that replaced it so
+	// that Delete (and every subsequent operation) acts on the running job.
+	d.SetId(newJobId)
+	if err := d.Set("job_id", newJobId); err != nil {
+		return fmt.Errorf("Error setting job_id: %s", err)
+	}
+
+	if err := resourceDataflowFlexTemplateJobRead(d, meta); err != nil {
+		return err
+	}
+
+	// Read just repopulated state/replaced_by_job_id from the new job; report
+	// the old job's values instead, since those are what actually transitioned
+	// as a result of this update.
+	if oldJobState != "" {
+		if err := d.Set("state", oldJobState); err != nil {
+			return fmt.Errorf("Error setting state: %s", err)
+		}
+	}
+	if replacedByJobId != "" {
+		if err := d.Set("replaced_by_job_id", replacedByJobId); err != nil {
+			return fmt.Errorf("Error setting replaced_by_job_id: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func resourceDataflowFlexTemplateJobRead(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*Config)
+	userAgent, err := generateUserAgentString(d, config.userAgent)
+	if err != nil {
+		return err
+	}
+
+	project, err := getProject(d, config)
+	if err != nil {
+		return err
+	}
+
+	region, err := getRegion(d, config)
+	if err != nil {
+		return err
+	}
+
+	job, err := config.NewDataflowClient(userAgent).Projects.Locations.Jobs.Get(project, region, d.Id()).Do()
+	if err != nil {
+		return handleNotFoundError(err, d, fmt.Sprintf("Dataflow job %s", d.Id()))
+	}
+
+	if err := d.Set("state", job.CurrentState); err != nil {
+		return fmt.Errorf("Error setting state: %s", err)
+	}
+	if err := d.Set("name", job.Name); err != nil {
+		return fmt.Errorf("Error setting name: %s", err)
+	}
+	if err := d.Set("project", project); err != nil {
+		return fmt.Errorf("Error setting project: %s", err)
+	}
+	if err := d.Set("region", region); err != nil {
+		return fmt.Errorf("Error setting region: %s", err)
+	}
+	if err := d.Set("replaced_by_job_id", job.ReplacedByJobId); err != nil {
+		return fmt.Errorf("Error setting replaced_by_job_id: %s", err)
+	}
+	if err := d.Set("labels", job.Labels); err != nil {
+		return fmt.Errorf("Error setting labels: %s", err)
+	}
+	if job.Environment != nil {
+		if err := d.Set("additional_experiments", job.Environment.Experiments); err != nil {
+			return fmt.Errorf("Error setting additional_experiments: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func resourceDataflowFlexTemplateJobDelete(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*Config)
+	userAgent, err := generateUserAgentString(d, config.userAgent)
+	if err != nil {
+		return err
+	}
+
+	project, err := getProject(d, config)
+	if err != nil {
+		return err
+	}
+
+	region, err := getRegion(d, config)
+	if err != nil {
+		return err
+	}
+
+	requestedState := dataflowJobStateCanceled
+	if d.Get("on_delete").(string) == "drain" {
+		requestedState = dataflowJobStateDrained
+	}
+
+	job := &dataflow.Job{
+		RequestedState: requestedState,
+	}
+
+	_, err = config.NewDataflowClient(userAgent).Projects.Locations.Jobs.Update(project, region, d.Id(), job).Do()
+	if err != nil {
+		return fmt.Errorf("Error requesting job termination: %s", err)
+	}
+
+	if d.Get("skip_wait_on_job_termination").(bool) {
+		return nil
+	}
+
+	return resource.Retry(d.Timeout(schema.TimeoutDelete), func() *resource.RetryError {
+		j, err := config.NewDataflowClient(userAgent).Projects.Locations.Jobs.Get(project, region, d.Id()).Do()
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		if !dataflowJobIsTerminal(j.CurrentState) {
+			return resource.RetryableError(fmt.Errorf("job %q is still in state %q", d.Id(), j.CurrentState))
+		}
+		log.Printf("[DEBUG] dataflow flex template job %q reached terminal state %q", d.Id(), j.CurrentState)
+		return nil
+	})
+}
+
+func dataflowJobIsTerminal(state string) bool {
+	switch state {
+	case dataflowJobStateDone, dataflowJobStateFailed, dataflowJobStateCanceled, dataflowJobStateDrained, dataflowJobStateUpdated:
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	dataflowJobStateDone     = "JOB_STATE_DONE"
+	dataflowJobStateFailed   = "JOB_STATE_FAILED"
+	dataflowJobStateCanceled = "JOB_STATE_CANCELLED"
+	dataflowJobStateDrained  = "JOB_STATE_DRAINED"
+	dataflowJobStateUpdated  = "JOB_STATE_UPDATED"
+	dataflowJobStateRunning  = "JOB_STATE_RUNNING"
+)
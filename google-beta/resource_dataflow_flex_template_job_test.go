@@ -64,6 +64,73 @@ func TestAccDataflowFlexTemplateJob_withServiceAccount(t *testing.T) {
 	})
 }
 
+func TestAccDataflowFlexTemplateJob_updateStreaming(t *testing.T) {
+	// This resource uses custom retry logic that cannot be sped up without
+	// modifying the actual resource
+	skipIfVcr(t)
+	t.Parallel()
+
+	randStr := randString(t, 10)
+	bucket := "tf-test-dataflow-gcs-" + randStr
+	job := "tf-test-dataflow-job-" + randStr
+
+	vcrTest(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckDataflowJobDestroyProducer(t),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataflowFlexTemplateJob_updateStreaming(bucket, job, "my-subscription"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccDataflowJobExists(t, "google_dataflow_flex_template_job.big_data"),
+					resource.TestCheckResourceAttr("google_dataflow_flex_template_job.big_data", "state", "JOB_STATE_RUNNING"),
+				),
+			},
+			{
+				Config: testAccDataflowFlexTemplateJob_updateStreaming(bucket, job, "my-other-subscription"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccDataflowJobExists(t, "google_dataflow_flex_template_job.big_data"),
+					resource.TestCheckResourceAttr("google_dataflow_flex_template_job.big_data", "state", "JOB_STATE_UPDATED"),
+					resource.TestCheckResourceAttrSet("google_dataflow_flex_template_job.big_data", "replaced_by_job_id"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccDataflowFlexTemplateJob_withUserLabelsAndExperiments(t *testing.T) {
+	// This resource uses custom retry logic that cannot be sped up without
+	// modifying the actual resource
+	skipIfVcr(t)
+	t.Parallel()
+
+	randStr := randString(t, 10)
+	bucket := "tf-test-dataflow-gcs-" + randStr
+	job := "tf-test-dataflow-job-" + randStr
+
+	vcrTest(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckDataflowJobDestroyProducer(t),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataflowFlexTemplateJob_withLabels(bucket, job),
+				Check: resource.ComposeTestCheckFunc(
+					testAccDataflowJobExists(t, "google_dataflow_flex_template_job.big_data"),
+					resource.TestCheckResourceAttr("google_dataflow_flex_template_job.big_data", "labels.my_label", "my_value"),
+					resource.TestCheckResourceAttr("google_dataflow_flex_template_job.big_data", "additional_experiments.#", "1"),
+				),
+			},
+			{
+				// Dataflow stamps its own labels (e.g. goog-dataflow-provided-template-name)
+				// and experiments onto the job at submission time; the plan should stay clean.
+				Config:   testAccDataflowFlexTemplateJob_withLabels(bucket, job),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
 func testAccDataflowFlexTemplateJobHasServiceAccount(t *testing.T, res, expectedId, zone string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
 		instance, err := testAccDataflowFlexTemplateJobGetGeneratedInstance(t, s, res, zone)
@@ -243,11 +310,11 @@ resource "google_dataflow_flex_template_job" "big_data" {
   name = "%s"
   container_spec_gcs_path = "${google_storage_bucket.temp.url}/${google_storage_bucket_object.flex_template.name}"
   on_delete = "cancel"
+  service_account_email = google_service_account.dataflow-sa.email
+  zone = "%s"
   parameters = {
     inputSubscription = "my-subscription"
     outputTable  = "my-project:my-dataset.my-table"
-    serviceAccount = google_service_account.dataflow-sa.email
-    zone = "%s"
   }
   depends_on = [
     google_storage_bucket_iam_member.dataflow-gcs,
@@ -256,3 +323,121 @@ resource "google_dataflow_flex_template_job" "big_data" {
 }
 `, bucket, accountId, job, zone)
 }
+
+// note: this config creates a job that doesn't actually do anything
+func testAccDataflowFlexTemplateJob_updateStreaming(bucket, job, subscription string) string {
+	return fmt.Sprintf(`
+resource "google_storage_bucket" "temp" {
+  name = "%s"
+  force_destroy = true
+}
+
+resource "google_storage_bucket_object" "flex_template" {
+  name   = "flex_template.json"
+  bucket = google_storage_bucket.temp.name
+  content = <<EOF
+{
+    "image": "my-image",
+    "metadata": {
+        "description": "An Apache Beam streaming pipeline that reads JSON encoded messages from Pub/Sub, uses Beam SQL to transform the message data, and writes the results to a BigQuery",
+        "name": "Streaming Beam SQL",
+        "parameters": [
+            {
+                "helpText": "Pub/Sub subscription to read from.",
+                "label": "Pub/Sub input subscription.",
+                "name": "inputSubscription",
+                "regexes": [
+                    "[-_.a-zA-Z0-9]+"
+                ]
+            },
+            {
+                "helpText": "BigQuery table spec to write to, in the form 'project:dataset.table'.",
+                "is_optional": true,
+                "label": "BigQuery output table",
+                "name": "outputTable",
+                "regexes": [
+                    "[^:]+:[^.]+[.].+"
+                ]
+            }
+        ]
+    },
+    "sdkInfo": {
+        "language": "JAVA"
+    }
+}
+EOF
+}
+
+resource "google_dataflow_flex_template_job" "big_data" {
+  name = "%s"
+  container_spec_gcs_path = "${google_storage_bucket.temp.url}/${google_storage_bucket_object.flex_template.name}"
+  on_delete = "drain"
+  parameters = {
+    inputSubscription = "%s"
+    outputTable  = "my-project:my-dataset.my-table"
+  }
+}
+`, bucket, job, subscription)
+}
+
+// note: this config creates a job that doesn't actually do anything
+func testAccDataflowFlexTemplateJob_withLabels(bucket, job string) string {
+	return fmt.Sprintf(`
+resource "google_storage_bucket" "temp" {
+  name = "%s"
+  force_destroy = true
+}
+
+resource "google_storage_bucket_object" "flex_template" {
+  name   = "flex_template.json"
+  bucket = google_storage_bucket.temp.name
+  content = <<EOF
+{
+    "image": "my-image",
+    "metadata": {
+        "description": "An Apache Beam streaming pipeline that reads JSON encoded messages from Pub/Sub, uses Beam SQL to transform the message data, and writes the results to a BigQuery",
+        "name": "Streaming Beam SQL",
+        "parameters": [
+            {
+                "helpText": "Pub/Sub subscription to read from.",
+                "label": "Pub/Sub input subscription.",
+                "name": "inputSubscription",
+                "regexes": [
+                    "[-_.a-zA-Z0-9]+"
+                ]
+            },
+            {
+                "helpText": "BigQuery table spec to write to, in the form 'project:dataset.table'.",
+                "is_optional": true,
+                "label": "BigQuery output table",
+                "name": "outputTable",
+                "regexes": [
+                    "[^:]+:[^.]+[.].+"
+                ]
+            }
+        ]
+    },
+    "sdkInfo": {
+        "language": "JAVA"
+    }
+}
+EOF
+}
+
+resource "google_dataflow_flex_template_job" "big_data" {
+  name = "%s"
+  container_spec_gcs_path = "${google_storage_bucket.temp.url}/${google_storage_bucket_object.flex_template.name}"
+  on_delete = "cancel"
+  parameters = {
+    inputSubscription = "my-subscription"
+    outputTable  = "my-project:my-dataset.my-table"
+  }
+  labels = {
+    my_label = "my_value"
+  }
+  additional_experiments = [
+    "enable_stackdriver_agent_metrics",
+  ]
+}
+`, bucket, job)
+}